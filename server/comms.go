@@ -40,6 +40,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http2"
 	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
 	"www.velocidex.com/golang/velociraptor/constants"
 	crypto_proto "www.velocidex.com/golang/velociraptor/crypto/proto"
@@ -63,6 +64,21 @@ func PrepareFrontendMux(
 	router.Handle("/control", control(server_obj))
 	router.Handle("/reader", reader(config_obj, server_obj))
 
+	// The h2 endpoints multiplex cleanly over a single TCP
+	// connection so clients do not need the pad-packet trick to
+	// stay alive. Operators talking to legacy proxies that choke
+	// on HTTP/2 can disable this with Frontend.disable_http2.
+	if !config_obj.Frontend.DisableHttp2 {
+		router.Handle("/control2", control2(server_obj))
+		router.Handle("/reader2", reader2(config_obj, server_obj))
+	}
+
+	// The WebSocket transport is opt-in: older agents keep using
+	// /control and /reader unaffected.
+	if config_obj.Frontend.WsEnable {
+		router.Handle("/ws", ws(config_obj, server_obj))
+	}
+
 	// Publically accessible part of the filestore. NOTE: this
 	// does not have to be a physical directory - it is served
 	// from the filestore.
@@ -117,6 +133,28 @@ func StartFrontendHttps(
 		},
 	}
 
+	if !config_obj.Frontend.DisableHttp2 {
+		server.TLSConfig.NextProtos = append(server.TLSConfig.NextProtos, "h2")
+		err = http2.ConfigureServer(server, &http2.Server{
+			MaxConcurrentStreams: 1000,
+			MaxReadFrameSize:     1 << 20,
+			IdleTimeout:          15 * time.Second,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Operators behind a gRPC-aware load balancer can additionally
+	// stand up the ClientComms gRPC transport alongside the HTTP
+	// frontend. This is optional - a bind failure here (e.g. the
+	// port is already taken) must not take down /control and
+	// /reader, so we log it rather than aborting startup.
+	err = StartGRPCServer(ctx, wg, config_obj, server_obj)
+	if err != nil {
+		server_obj.Error("Unable to start gRPC frontend - gRPC transport disabled", err)
+	}
+
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
@@ -200,6 +238,27 @@ func StartTLSServer(
 		},
 	}
 
+	if !config_obj.Frontend.DisableHttp2 {
+		server.TLSConfig.NextProtos = append(server.TLSConfig.NextProtos, "h2")
+		err := http2.ConfigureServer(server, &http2.Server{
+			MaxConcurrentStreams: 1000,
+			MaxReadFrameSize:     1 << 20,
+			IdleTimeout:          15 * time.Second,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Also offer the gRPC transport on this deployment path, the
+	// same way StartFrontendHttps does. Like there, a failure to
+	// bind the optional gRPC port must not prevent the autocert
+	// HTTPS frontend from starting.
+	err := StartGRPCServer(ctx, wg, config_obj, server_obj)
+	if err != nil {
+		logger.Error("Unable to start gRPC frontend - gRPC transport disabled", err)
+	}
+
 	// We must have port 80 open to serve the HTTP 01 challenge.
 	go http.ListenAndServe(":http", certManager.HTTPHandler(nil))
 
@@ -0,0 +1,268 @@
+/*
+   Velociraptor - Hunting Evil
+   Copyright (C) 2019 Velocidex Innovations.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published
+   by the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package server
+
+import (
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/juju/ratelimit"
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	"www.velocidex.com/golang/velociraptor/constants"
+	"www.velocidex.com/golang/velociraptor/logging"
+	"www.velocidex.com/golang/velociraptor/services"
+	"www.velocidex.com/golang/velociraptor/utils"
+)
+
+// control2 is the HTTP/2 equivalent of control(). It is registered
+// at /control2 and relies on h2's native framing to keep the
+// connection alive instead of streaming serialized_pad packets -
+// the stream is kept alive with single-byte DATA frames while the
+// flow is processed.
+func control2(server_obj *Server) http.Handler {
+	logger := logging.GetLogger(server_obj.config, &logging.FrontendComponent)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if maybeRedirectFrontend("control2", w, req) {
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			panic("http handler is not a flusher")
+		}
+
+		priority := req.Header.Get("X-Priority")
+		if priority != "urgent" {
+			server_obj.StartConcurrencyControl()
+			defer server_obj.EndConcurrencyControl()
+		}
+
+		reader := io.LimitReader(req.Body, int64(server_obj.config.
+			Frontend.MaxUploadSize*2))
+
+		if server_obj.config.Frontend.PerClientUploadRate > 0 {
+			bucket := ratelimit.NewBucketWithRate(
+				float64(server_obj.config.Frontend.PerClientUploadRate),
+				100*1024)
+			reader = ratelimit.Reader(reader, bucket)
+		}
+
+		if server_obj.Bucket != nil {
+			reader = ratelimit.Reader(reader, server_obj.Bucket)
+		}
+
+		body, err := ioutil.ReadAll(reader)
+		if err != nil {
+			logger.Debug("Unable to read body from %v: %+v (read %v)",
+				req.RemoteAddr, err, len(body))
+			http.Error(w, "", http.StatusServiceUnavailable)
+			return
+		}
+
+		message_info, err := server_obj.Decrypt(req.Context(), body)
+		if err != nil {
+			logger.Debug("Unable to decrypt body from %v: %+v "+
+				"(%v out of max %v)",
+				req.RemoteAddr, err, len(body), server_obj.config.
+					Frontend.MaxUploadSize*2)
+			http.Error(w, "", http.StatusForbidden)
+			return
+		}
+		message_info.RemoteAddr = utils.RemoteAddr(req, server_obj.config.Frontend.GetProxyHeader())
+		logger.Debug("Received a post of length %v from %v (%v)", len(body),
+			message_info.RemoteAddr, message_info.Source)
+
+		if !message_info.Authenticated {
+			err := server_obj.ProcessUnauthenticatedMessages(
+				req.Context(), message_info)
+			if err == nil {
+				logger.Debug("Please Enrol (%v)", message_info.Source)
+				http.Error(
+					w,
+					"Please Enrol",
+					http.StatusNotAcceptable)
+			} else {
+				server_obj.Error("Unable to process", err)
+				logger.Debug("Unable to process (%v)", message_info.Source)
+				http.Error(w, "", http.StatusServiceUnavailable)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		sync := make(chan []byte)
+		go func() {
+			defer close(sync)
+			response, _, err := server_obj.Process(
+				req.Context(), message_info,
+				false, // drain_requests_for_client
+			)
+			if err != nil {
+				server_obj.Error("Error:", err)
+			} else {
+				sync <- response
+			}
+		}()
+
+		// Unlike control() there is no serialized_pad protobuf
+		// written here, but the keepalive byte below still has to
+		// be non-empty: net/http's HTTP/2 writer buffers output
+		// and Flush() on an empty buffer emits no DATA frame at
+		// all, so intermediaries would time the connection out
+		// exactly as before.
+		for {
+			select {
+			case response := <-sync:
+				w.Write(response)
+				return
+
+			case <-time.After(3 * time.Second):
+				w.Write([]byte{0})
+				flusher.Flush()
+			}
+		}
+	})
+}
+
+// reader2 is the HTTP/2 equivalent of reader(). Because h2 clients
+// multiplex many concurrent streams over a single TCP connection,
+// the single-instance IsClientConnected() check that reader()
+// enforces is lifted here.
+func reader2(config_obj *config_proto.Config, server_obj *Server) http.Handler {
+	logger := logging.GetLogger(config_obj, &logging.FrontendComponent)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if maybeRedirectFrontend("reader2", w, req) {
+			return
+		}
+
+		ctx := req.Context()
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			panic("http handler is not a flusher")
+		}
+
+		currentConnections.Inc()
+		defer currentConnections.Dec()
+
+		body, err := ioutil.ReadAll(
+			io.LimitReader(req.Body, constants.MAX_MEMORY))
+		if err != nil {
+			server_obj.Error("Unable to read body", err)
+			http.Error(w, "", http.StatusServiceUnavailable)
+			return
+		}
+
+		message_info, err := server_obj.Decrypt(req.Context(), body)
+		if err != nil {
+			http.Error(w, "", http.StatusForbidden)
+			return
+		}
+		message_info.RemoteAddr = req.RemoteAddr
+
+		if !message_info.Authenticated {
+			http.Error(w, "Please Enrol", http.StatusNotAcceptable)
+			return
+		}
+
+		source := message_info.Source
+		notification := services.ListenForNotification(source)
+
+		wait := time.Duration(config_obj.Client.MaxPoll+
+			uint64(rand.Intn(30))) * time.Second
+		deadline := time.After(wait)
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		defer services.NotifyListener(config_obj, source)
+
+		response, count, err := server_obj.Process(
+			req.Context(), message_info,
+			true, // drain_requests_for_client
+		)
+		if err != nil {
+			server_obj.Error("Error:", err)
+			return
+		}
+		if count > 0 {
+			_, err := w.Write(response)
+			if err != nil {
+				server_obj.Info("reader2: Error %v", err)
+			}
+			return
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case quit := <-notification:
+				if quit {
+					logger.Info("reader2: quit.")
+					return
+				}
+				response, _, err := server_obj.Process(
+					req.Context(),
+					message_info,
+					true, // drain_requests_for_client
+				)
+				if err != nil {
+					server_obj.Error("Error:", err)
+					return
+				}
+
+				_, err = w.Write(response)
+				if err != nil {
+					logger.Debug("reader2: Error %v", err)
+				}
+
+				flusher.Flush()
+				return
+
+			case <-deadline:
+				services.NotifyListener(config_obj, source)
+
+				// Keep the h2 stream alive with a single
+				// byte DATA frame rather than a pad packet -
+				// Flush() on an empty buffer writes nothing
+				// to the wire, which would let intermediaries
+				// time the connection out silently.
+			case <-time.After(10 * time.Second):
+				_, err := w.Write([]byte{0})
+				if err != nil {
+					logger.Info("reader2: Error %v", err)
+					return
+				}
+
+				flusher.Flush()
+			}
+		}
+	})
+}
@@ -0,0 +1,252 @@
+/*
+   Velociraptor - Hunting Evil
+   Copyright (C) 2019 Velocidex Innovations.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published
+   by the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	comms_proto "www.velocidex.com/golang/velociraptor/server/proto"
+	"www.velocidex.com/golang/velociraptor/services"
+)
+
+// clientCommsServer implements the ClientComms gRPC service. It
+// reuses the same Decrypt()/Process() pipeline as the /control and
+// /reader HTTP handlers so a client connected over gRPC is
+// indistinguishable from one using the long-poll transport.
+type clientCommsServer struct {
+	config_obj *config_proto.Config
+	server_obj *Server
+}
+
+// Control is the streaming equivalent of the control() HTTP
+// handler: the client pushes encrypted payloads and we reply with
+// the server's response to each one.
+func (self *clientCommsServer) Control(
+	stream comms_proto.ClientComms_ControlServer) error {
+
+	// Shared across the whole stream, the same way control() scopes
+	// a single bucket to one POST - but here the stream can carry
+	// many messages, so the bucket keeps throttling for as long as
+	// the client stays connected instead of resetting every message.
+	bucket := newUploadRateBucket(self.server_obj.config.Frontend.PerClientUploadRate)
+
+	for {
+		payload, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+
+		// Throttle on the decoded payload size, since stream.Recv()
+		// already gave us the whole message rather than a Reader we
+		// could wrap.
+		if bucket != nil {
+			bucket.Wait(int64(len(payload.Data)))
+		}
+		if self.server_obj.Bucket != nil {
+			self.server_obj.Bucket.Wait(int64(len(payload.Data)))
+		}
+
+		message_info, err := self.server_obj.Decrypt(stream.Context(), payload.Data)
+		if err != nil {
+			return err
+		}
+		if p, ok := peer.FromContext(stream.Context()); ok {
+			message_info.RemoteAddr = p.Addr.String()
+		}
+
+		// Only pin a concurrency control slot while a Process()
+		// call is actually in flight, not for the stream's entire
+		// lifetime - a long-lived Control stream would otherwise
+		// starve the bucket that HTTP control() relies on.
+		self.server_obj.StartConcurrencyControl()
+
+		if !message_info.Authenticated {
+			err := self.server_obj.ProcessUnauthenticatedMessages(
+				stream.Context(), message_info)
+			self.server_obj.EndConcurrencyControl()
+			if err != nil {
+				self.server_obj.Error("Unable to process", err)
+				return err
+			}
+
+			// The client can not decrypt a normal reply yet, so
+			// (like the HTTP handler's 406 "Please Enrol") we end
+			// the stream with a distinguishable status instead of
+			// silently going around the loop again.
+			return status.Error(codes.FailedPrecondition, "Please Enrol")
+		}
+
+		response, _, err := self.server_obj.Process(
+			stream.Context(), message_info,
+			false, // drain_requests_for_client
+		)
+		self.server_obj.EndConcurrencyControl()
+		if err != nil {
+			self.server_obj.Error("Error:", err)
+			return err
+		}
+
+		err = stream.Send(&comms_proto.Payload{Data: response})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Poll is the streaming equivalent of the reader() HTTP handler: we
+// push tasking to the client as soon as a notification fires for
+// its source. gRPC streams register/deregister with
+// IsClientConnected the same way reader() does.
+func (self *clientCommsServer) Poll(
+	stream comms_proto.ClientComms_PollServer) error {
+
+	payload, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+
+	message_info, err := self.server_obj.Decrypt(stream.Context(), payload.Data)
+	if err != nil {
+		return err
+	}
+
+	if !message_info.Authenticated {
+		return fmt.Errorf("Please Enrol")
+	}
+
+	source := message_info.Source
+	if services.IsClientConnected(source) {
+		return fmt.Errorf("Another Client connection exists for %v", source)
+	}
+
+	currentConnections.Inc()
+	defer currentConnections.Dec()
+
+	notification := services.ListenForNotification(source)
+	defer services.NotifyListener(self.config_obj, source)
+
+	response, count, err := self.server_obj.Process(
+		stream.Context(), message_info,
+		true, // drain_requests_for_client
+	)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return stream.Send(&comms_proto.Payload{Data: response})
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+
+		case quit := <-notification:
+			if quit {
+				return nil
+			}
+
+			response, _, err := self.server_obj.Process(
+				stream.Context(), message_info,
+				true, // drain_requests_for_client
+			)
+			if err != nil {
+				return err
+			}
+
+			return stream.Send(&comms_proto.Payload{Data: response})
+		}
+	}
+}
+
+// StartGRPCServer starts the ClientComms gRPC transport on
+// Frontend.GrpcBindPort, reusing the same TLS certificate material
+// as StartFrontendHttps. It is a no-op when GrpcBindPort is unset so
+// operators who do not need a gRPC-aware load balancer pay no cost.
+func StartGRPCServer(
+	ctx context.Context,
+	wg *sync.WaitGroup,
+	config_obj *config_proto.Config,
+	server_obj *Server) error {
+
+	if config_obj.Frontend.GrpcBindPort == 0 {
+		return nil
+	}
+
+	cert, err := tls.X509KeyPair(
+		[]byte(config_obj.Frontend.Certificate),
+		[]byte(config_obj.Frontend.PrivateKey))
+	if err != nil {
+		return err
+	}
+
+	listenAddr := fmt.Sprintf(
+		"%s:%d",
+		config_obj.Frontend.BindAddress,
+		config_obj.Frontend.GrpcBindPort)
+
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return err
+	}
+
+	creds := credentials.NewTLS(&tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	})
+
+	grpc_server := grpc.NewServer(grpc.Creds(creds))
+	comms_proto.RegisterClientCommsServer(grpc_server, &clientCommsServer{
+		config_obj: config_obj,
+		server_obj: server_obj,
+	})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		server_obj.Info("Frontend is ready to handle client gRPC requests at %s", listenAddr)
+
+		err := grpc_server.Serve(listener)
+		if err != nil {
+			server_obj.Error("gRPC Frontend server error", err)
+		}
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-ctx.Done()
+
+		server_obj.Info("Shutting down gRPC frontend")
+		services.NotifyAllListeners(config_obj)
+		grpc_server.GracefulStop()
+		server_obj.Info("Shut down gRPC frontend")
+	}()
+
+	return nil
+}
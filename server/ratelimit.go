@@ -0,0 +1,32 @@
+/*
+   Velociraptor - Hunting Evil
+   Copyright (C) 2019 Velocidex Innovations.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published
+   by the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package server
+
+import "github.com/juju/ratelimit"
+
+// newUploadRateBucket builds the per-client upload rate bucket that
+// control() constructs inline for each POST. The gRPC and WebSocket
+// transports share this helper so a connection that stays open for
+// many messages is throttled to the same rate as the HTTP handlers,
+// rather than rebuilding (and resetting) a bucket per message.
+func newUploadRateBucket(rate_per_second uint64) *ratelimit.Bucket {
+	if rate_per_second == 0 {
+		return nil
+	}
+	return ratelimit.NewBucketWithRate(float64(rate_per_second), 100*1024)
+}
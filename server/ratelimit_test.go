@@ -0,0 +1,23 @@
+package server
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNewUploadRateBucket(t *testing.T) {
+	if bucket := newUploadRateBucket(0); bucket != nil {
+		t.Fatalf("expected no bucket when the rate is disabled, got %v", bucket)
+	}
+
+	bucket := newUploadRateBucket(1024)
+	if bucket == nil {
+		t.Fatal("expected a bucket when a rate is configured")
+	}
+	// NewBucketWithRate rounds its fill interval to whole
+	// nanoseconds, so the rate it reports is only guaranteed to be
+	// within 1% of the requested one (1024 gives about 1024.0005).
+	if got, want := bucket.Rate(), float64(1024); math.Abs(got-want)/want > 0.01 {
+		t.Fatalf("bucket rate = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,258 @@
+/*
+   Velociraptor - Hunting Evil
+   Copyright (C) 2019 Velocidex Innovations.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published
+   by the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package server
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	"www.velocidex.com/golang/velociraptor/logging"
+	"www.velocidex.com/golang/velociraptor/services"
+)
+
+// websocketWriter serializes writes to a *websocket.Conn - a single
+// client session both relays responses to inbound payloads and
+// pushes tasking notifications, and gorilla/websocket does not
+// allow concurrent writers on the same connection.
+type websocketWriter struct {
+	mu   sync.Mutex
+	conn *websocket.Conn
+}
+
+func (self *websocketWriter) WriteBinary(data []byte) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	return self.conn.WriteMessage(websocket.BinaryMessage, data)
+}
+
+func (self *websocketWriter) WritePing() error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	return self.conn.WriteMessage(websocket.PingMessage, []byte{})
+}
+
+func (self *websocketWriter) WriteClose(code int, text string) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	return self.conn.WriteMessage(websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, text))
+}
+
+// ws is the WebSocket equivalent of control()/reader(): a single
+// upgraded connection carries both the client's outgoing payloads
+// and the server's tasking, replacing the 10-second pad-packet loop
+// with a single frame per notification and PING/PONG keepalive.
+func ws(config_obj *config_proto.Config, server_obj *Server) http.Handler {
+	logger := logging.GetLogger(config_obj, &logging.FrontendComponent)
+
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  int(config_obj.Frontend.WsMaxFrameSize),
+		WriteBufferSize: int(config_obj.Frontend.WsMaxFrameSize),
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if maybeRedirectFrontend("ws", w, req) {
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, req, nil)
+		if err != nil {
+			logger.Debug("ws: unable to upgrade connection from %v: %v",
+				req.RemoteAddr, err)
+			return
+		}
+		defer conn.Close()
+
+		if config_obj.Frontend.WsMaxFrameSize > 0 {
+			conn.SetReadLimit(int64(config_obj.Frontend.WsMaxFrameSize))
+		}
+
+		currentConnections.Inc()
+		defer currentConnections.Dec()
+
+		// Without a configured Frontend.ws_idle_timeout, use a
+		// jittered deadline analogous to the reader() pad-packet
+		// loop, so clients do not all reconnect in lockstep.
+		idle_timeout := time.Duration(config_obj.Frontend.WsIdleTimeout) * time.Second
+		if idle_timeout == 0 {
+			idle_timeout = time.Duration(config_obj.Client.MaxPoll+
+				uint64(rand.Intn(30))) * time.Second
+		}
+
+		conn.SetReadDeadline(time.Now().Add(idle_timeout))
+		conn.SetPongHandler(func(string) error {
+			conn.SetReadDeadline(time.Now().Add(idle_timeout))
+			return nil
+		})
+
+		out := &websocketWriter{conn: conn}
+
+		// One bucket per connection, not per frame - a chatty client
+		// sending many small frames should still be throttled to the
+		// same aggregate rate as one sending a few large ones.
+		bucket := newUploadRateBucket(config_obj.Frontend.PerClientUploadRate)
+		throttle := func(n int) {
+			if bucket != nil {
+				bucket.Wait(int64(n))
+			}
+			if server_obj.Bucket != nil {
+				server_obj.Bucket.Wait(int64(n))
+			}
+		}
+
+		// The first frame establishes the session: it must be an
+		// authenticated ClientCommunication, the same requirement
+		// reader() places on its initial POST body.
+		_, body, err := conn.ReadMessage()
+		if err != nil {
+			logger.Debug("ws: unable to read from %v: %v", req.RemoteAddr, err)
+			return
+		}
+		throttle(len(body))
+
+		message_info, err := server_obj.Decrypt(req.Context(), body)
+		if err != nil {
+			out.WriteClose(websocket.ClosePolicyViolation, "")
+			return
+		}
+		message_info.RemoteAddr = req.RemoteAddr
+
+		if !message_info.Authenticated {
+			err := server_obj.ProcessUnauthenticatedMessages(req.Context(), message_info)
+			if err != nil {
+				server_obj.Error("Unable to process", err)
+			}
+			out.WriteClose(websocket.ClosePolicyViolation, "Please Enrol")
+			return
+		}
+
+		source := message_info.Source
+		if services.IsClientConnected(source) {
+			out.WriteClose(websocket.ClosePolicyViolation,
+				"Another Client connection exists")
+			return
+		}
+
+		notification := services.ListenForNotification(source)
+		defer services.NotifyListener(config_obj, source)
+
+		ctx, cancel := context.WithCancel(req.Context())
+		defer cancel()
+
+		// Process the initial payload and reply on the same
+		// connection. Like reader(), this also drains any requests
+		// already queued for the client.
+		response, _, err := server_obj.Process(
+			ctx, message_info,
+			true, // drain_requests_for_client
+		)
+		if err != nil {
+			server_obj.Error("Error:", err)
+			return
+		}
+		if err := out.WriteBinary(response); err != nil {
+			logger.Debug("ws: Error %v", err)
+			return
+		}
+
+		// Subsequent inbound frames are handled the same way
+		// control() handles each POST - each one is decrypted and
+		// processed independently.
+		go func() {
+			defer cancel()
+
+			for {
+				_, body, err := conn.ReadMessage()
+				if err != nil {
+					return
+				}
+				throttle(len(body))
+
+				next_info, err := server_obj.Decrypt(ctx, body)
+				if err != nil {
+					return
+				}
+				next_info.RemoteAddr = req.RemoteAddr
+
+				// Every subsequent frame is re-checked for
+				// authentication, the same as control() rejects
+				// each individual POST from an unauthenticated
+				// client - the initial frame's authentication does
+				// not carry over to the rest of the session.
+				if !next_info.Authenticated {
+					out.WriteClose(websocket.ClosePolicyViolation, "Please Enrol")
+					return
+				}
+
+				response, _, err := server_obj.Process(
+					ctx, next_info,
+					false, // drain_requests_for_client
+				)
+				if err != nil {
+					server_obj.Error("Error:", err)
+					return
+				}
+
+				if err := out.WriteBinary(response); err != nil {
+					return
+				}
+			}
+		}()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case quit := <-notification:
+				if quit {
+					logger.Info("ws: quit.")
+					return
+				}
+
+				response, _, err := server_obj.Process(
+					ctx, message_info,
+					true, // drain_requests_for_client
+				)
+				if err != nil {
+					server_obj.Error("Error:", err)
+					return
+				}
+
+				if err := out.WriteBinary(response); err != nil {
+					logger.Debug("ws: Error %v", err)
+					return
+				}
+
+			case <-time.After(idle_timeout / 3):
+				if err := out.WritePing(); err != nil {
+					logger.Debug("ws: ping error %v", err)
+					return
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,101 @@
+/*
+   Velociraptor - Hunting Evil
+   Copyright (C) 2019 Velocidex Innovations.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published
+   by the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package server
+
+// websocketWriter is the only piece of ws() that is self contained
+// enough to exercise here: everything else in the handler - the
+// enrollment check, the per-frame rate limiting - hangs off the
+// Decrypt()/Process() pipeline on
+// *Server, and *Server itself is not part of this snapshot (it comes
+// from crypto_proto/services/file_store, none of which are present
+// in this tree). Those paths should get the same unauthenticated and
+// rate-limit coverage as TestNewUploadRateBucket once *Server is
+// available to construct in a test.
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestWebsocketWriterConcurrentWrites confirms websocketWriter
+// actually serializes writes: gorilla/websocket panics (or corrupts
+// the frame stream) if two goroutines call WriteMessage on the same
+// *websocket.Conn concurrently, which is exactly what ws() does by
+// calling out.WriteBinary() from both its read loop and its
+// notification/ping select loop.
+func TestWebsocketWriterConcurrentWrites(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			conn, err := upgrader.Upgrade(w, req, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+
+			// Drain whatever the client sends so it doesn't block on
+			// a full write buffer while we hammer it from the server
+			// side below.
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}))
+	defer server.Close()
+
+	url := "ws" + server.URL[len("http"):]
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("unable to dial test server: %v", err)
+	}
+	defer conn.Close()
+
+	out := &websocketWriter{conn: conn}
+
+	const goroutines = 10
+	const writesEach = 50
+
+	var wg sync.WaitGroup
+	errs := make(chan error, goroutines*writesEach)
+
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < writesEach; j++ {
+				if err := out.WriteBinary([]byte("payload")); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatalf("concurrent WriteBinary failed: %v", err)
+	}
+}